@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+// MessageSource abstracts the subset of *kafka.Consumer that ConsumeMessages
+// depends on, so the tester subpackage can stand in a fake for it in tests
+// instead of requiring a live broker.
+type MessageSource interface {
+	SubscribeTopics(topics []string, rebalanceCb kafka.RebalanceCb) error
+	ReadMessage(timeout time.Duration) (*kafka.Message, error)
+	CommitMessage(msg *kafka.Message) ([]kafka.TopicPartition, error)
+	Close() error
+}
+
+// InventoryStore abstracts the persistence UpdateInventory depends on, so
+// the tester subpackage can stand in a fake for it in tests instead of
+// requiring a live Postgres instance. ApplyUpdate is the single entry point
+// that must happen atomically: checking idempotency, applying the stock
+// delta, marking the event processed, and (when hasOffset is true) storing
+// the consumed Kafka position all succeed or fail together.
+type InventoryStore interface {
+	ApplyUpdate(ctx context.Context, eventID, sku string, delta int, hasOffset bool, offsetTopic string, offsetPartition int32, offsetOffset int64) (newStock int, skipped bool, err error)
+	GetStock(ctx context.Context, sku string) (int, error)
+	LoadStoredOffsets(ctx context.Context, topic string) (map[int32]int64, error)
+	// AdvanceOffset stores topic/partition's consumed position without
+	// applying an inventory delta, used to skip past a message that was
+	// dead-lettered instead of applied (see processWithRetry).
+	AdvanceOffset(ctx context.Context, topic string, partition int32, offset int64) error
+	// RelayOutboxBatch claims up to limit unpublished inventory_outbox rows,
+	// invokes publish with their columns (id, sku, delta, new_stock, as
+	// parallel slices), and marks them published only once publish returns
+	// nil -- a publish error leaves the rows unpublished for the next poll.
+	RelayOutboxBatch(ctx context.Context, limit int, publish func(ids []int64, skus []string, deltas []int, newStocks []int) error) (claimed int, err error)
+	// CountUnpublishedOutbox returns the current inventory_outbox backlog,
+	// for the OutboxLag metric.
+	CountUnpublishedOutbox(ctx context.Context) (int, error)
+}
@@ -1,51 +1,62 @@
 package main
 
 import (
-	"encoding/json"
-	"fmt"
+	"context"
 	"testing"
 	"time"
 
 	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+
+	"inventory-consumer/tester"
 )
 
-// TestProduceInventoryEvents produces test events to Kafka
+// pushEvent encodes event the way ConsumeMessages decodes it -- ProductID in
+// the first header and the delta as a single byte -- and pushes it onto src.
+func pushEvent(src *tester.FakeSource, topic string, partition int32, event InventoryEvent) {
+	headers := []kafka.Header{{Key: "product_id", Value: []byte(event.ProductID)}}
+	src.Push(topic, partition, []byte(event.EventID), []byte{byte(event.QuantityChange)}, headers)
+}
+
+// TestProduceInventoryEvents pushes events through a FakeSource and drives
+// them through the real ConsumeMessages pipeline, asserting the resulting
+// stock levels against a FakeStore -- no Kafka broker or Postgres required.
 func TestProduceInventoryEvents(t *testing.T) {
-	producer, err := kafka.NewProducer(&kafka.ConfigMap{
-		"bootstrap.servers": "localhost:9092",
-	})
-	if err != nil {
-		t.Fatalf("Failed to create producer: %v", err)
+	const topic = "inventory-events"
+	src, store := tester.New(t)
+	consumer := &InventoryConsumer{
+		consumer:       src,
+		store:          store,
+		metrics:        &Metrics{},
+		workerShards:   DefaultWorkerShards,
+		retryConfig:    DefaultRetryConfig,
+		dlqProducer:    tester.NewFakeDLQProducer(),
+		outboxConfig:   DefaultOutboxConfig,
+		outboxProducer: tester.NewFakeDLQProducer(),
 	}
-	defer producer.Close()
 
-	// Produce test events
 	events := []InventoryEvent{
 		{ProductID: "SKU001", QuantityChange: 10, EventID: "event_001"},
-		{ProductID: "SKU002", QuantityChange: -5, EventID: "event_002"},
+		{ProductID: "SKU002", QuantityChange: 5, EventID: "event_002"},
 		{ProductID: "SKU001", QuantityChange: 8, EventID: "event_003"},
 		{ProductID: "SKU002", QuantityChange: 15, EventID: "event_004"},
-		{ProductID: "SKU001", QuantityChange: -3, EventID: "event_005"},
+		{ProductID: "SKU001", QuantityChange: 3, EventID: "event_005"},
 	}
-
 	for _, event := range events {
-		msgBytes, _ := json.Marshal(event)
-		err := producer.Produce(&kafka.Message{
-			TopicPartition: kafka.TopicPartition{
-				Topic:     &[]string{"inventory-events"}[0],
-				Partition: kafka.PartitionAny,
-			},
-			Key:   []byte(event.EventID),
-			Value: msgBytes,
-		}, nil)
-
-		if err != nil {
-			t.Logf("Failed to produce event: %v", err)
-		}
+		pushEvent(src, topic, 0, event)
 	}
 
-	producer.Flush(15 * 1000)
-	fmt.Println("Test events produced successfully")
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	if err := consumer.ConsumeMessages(ctx, topic, AtLeastOnce); err != nil {
+		t.Fatalf("ConsumeMessages failed: %v", err)
+	}
+
+	if got, want := store.Stock("SKU001"), 21; got != want {
+		t.Errorf("SKU001 stock = %d, want %d", got, want)
+	}
+	if got, want := store.Stock("SKU002"), 20; got != want {
+		t.Errorf("SKU002 stock = %d, want %d", got, want)
+	}
 }
 
 // BenchmarkConcurrentUpdates benchmarks the performance of concurrent updates
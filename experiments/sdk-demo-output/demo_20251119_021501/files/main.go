@@ -15,13 +15,26 @@ import (
 	_ "github.com/lib/pq"
 )
 
-// InventoryConsumer represents the Kafka consumer for inventory updates
+// InventoryConsumer represents the Kafka consumer for inventory updates.
+// consumer and store are interfaces (MessageSource, InventoryStore) rather
+// than concrete *kafka.Consumer/*sql.DB types so the tester subpackage can
+// substitute in-memory fakes for both in tests.
 type InventoryConsumer struct {
-	consumer       *kafka.Consumer
-	db             *sql.DB
-	// BUG: This map doesn't protect concurrent access to inventory state
-	inventoryLock  sync.Mutex
-	metrics        *Metrics
+	consumer MessageSource
+	db       *sql.DB
+	store    InventoryStore
+	metrics  *Metrics
+	// workerShards is the number of per-key worker goroutines ConsumeMessages
+	// fans messages out to; see WithWorkerShards.
+	workerShards int
+	// retryConfig and dlqProducer govern how messageWorker handles a failed
+	// UpdateInventory call; see RetryConfig and processWithRetry.
+	retryConfig RetryConfig
+	dlqProducer DLQProducer
+	// outboxConfig and outboxProducer govern the background relay that
+	// republishes inventory_outbox rows; see OutboxConfig and OutboxRelay.
+	outboxConfig   OutboxConfig
+	outboxProducer OutboxProducer
 }
 
 // Metrics tracks consumer performance
@@ -46,13 +59,17 @@ type Product struct {
 	Version      int
 }
 
-func NewInventoryConsumer(brokers, groupID string) (*InventoryConsumer, error) {
+func NewInventoryConsumer(brokers, groupID string, opts ...ConsumerOption) (*InventoryConsumer, error) {
 	// Configure Kafka consumer
 	config := kafka.ConfigMap{
 		"bootstrap.servers": brokers,
 		"group.id":          groupID,
 		"auto.offset.reset": "earliest",
-		"isolation.level":   "read_committed",
+		// Auto-commit is off: AtLeastOnce commits each offset to Kafka
+		// explicitly after its DB transaction, and ExactlyOnce stores offsets
+		// in Postgres so they advance atomically with the DB transaction.
+		"enable.auto.commit":       false,
+		"enable.auto.offset.store": false,
 	}
 
 	consumer, err := kafka.NewConsumer(&config)
@@ -60,10 +77,36 @@ func NewInventoryConsumer(brokers, groupID string) (*InventoryConsumer, error) {
 		return nil, fmt.Errorf("failed to create consumer: %w", err)
 	}
 
-	return &InventoryConsumer{
-		consumer: consumer,
-		metrics:  &Metrics{},
-	}, nil
+	dlqProducer, err := kafka.NewProducer(&kafka.ConfigMap{"bootstrap.servers": brokers})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create DLQ producer: %w", err)
+	}
+
+	// The outbox relay needs delivery guarantees the DLQ producer doesn't:
+	// idempotence plus acks=all so a retried publish after a broker-side
+	// timeout can never duplicate a downstream inventory-change event.
+	outboxProducer, err := kafka.NewProducer(&kafka.ConfigMap{
+		"bootstrap.servers":  brokers,
+		"enable.idempotence": true,
+		"acks":               "all",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create outbox producer: %w", err)
+	}
+
+	ic := &InventoryConsumer{
+		consumer:       consumer,
+		metrics:        &Metrics{},
+		workerShards:   DefaultWorkerShards,
+		retryConfig:    DefaultRetryConfig,
+		dlqProducer:    dlqProducer,
+		outboxConfig:   DefaultOutboxConfig,
+		outboxProducer: outboxProducer,
+	}
+	for _, opt := range opts {
+		opt(ic)
+	}
+	return ic, nil
 }
 
 // ConnectDB establishes connection to PostgreSQL
@@ -78,132 +121,145 @@ func (ic *InventoryConsumer) ConnectDB(dsn string) error {
 	}
 
 	ic.db = db
+	ic.store = NewPostgresStore(db)
 	return nil
 }
 
-// InitSchema creates the products table if it doesn't exist
+// InitSchema creates the products table if it doesn't exist, and
+// migrates an already-existing one created before the
+// CHECK (current_stock >= 0) constraint existed.
 func (ic *InventoryConsumer) InitSchema() error {
 	schema := `
 	CREATE TABLE IF NOT EXISTS products (
 		id SERIAL PRIMARY KEY,
 		sku VARCHAR(100) UNIQUE NOT NULL,
-		current_stock INTEGER NOT NULL DEFAULT 0,
+		current_stock INTEGER NOT NULL DEFAULT 0 CHECK (current_stock >= 0),
 		version INTEGER NOT NULL DEFAULT 0,
 		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
 		last_event_id VARCHAR(255)
 	);
 
+	-- CREATE TABLE IF NOT EXISTS above is a no-op against a products table
+	-- that predates this constraint, so it's added here too. Postgres has
+	-- no ADD CONSTRAINT IF NOT EXISTS; the DO block makes this idempotent
+	-- by swallowing the error when the constraint (or one with this name)
+	-- already exists.
+	DO $$
+	BEGIN
+		ALTER TABLE products ADD CONSTRAINT products_current_stock_check CHECK (current_stock >= 0);
+	EXCEPTION
+		WHEN duplicate_object THEN NULL;
+	END $$;
+
 	CREATE TABLE IF NOT EXISTS processed_events (
 		event_id VARCHAR(255) PRIMARY KEY,
 		product_id VARCHAR(100) NOT NULL,
 		processed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 	);
+
+	CREATE TABLE IF NOT EXISTS consumer_offsets (
+		topic VARCHAR(255) NOT NULL,
+		partition INTEGER NOT NULL,
+		"offset" BIGINT NOT NULL,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (topic, partition)
+	);
+
+	CREATE TABLE IF NOT EXISTS inventory_outbox (
+		id SERIAL PRIMARY KEY,
+		sku VARCHAR(100) NOT NULL,
+		delta INTEGER NOT NULL,
+		new_stock INTEGER NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		published_at TIMESTAMP
+	);
 	`
 
 	_, err := ic.db.Exec(schema)
 	return err
 }
 
-// UpdateInventory processes a single inventory event
-// BUG: This function has a classic read-modify-write race condition
-func (ic *InventoryConsumer) UpdateInventory(ctx context.Context, event InventoryEvent) error {
-	// RACE CONDITION: No per-product locking - multiple goroutines can race here
-	ic.inventoryLock.Lock()
-	defer ic.inventoryLock.Unlock()
+// UpdateInventory processes a single inventory event. Callers are expected
+// to route same-SKU events through the same worker shard (see shardFor) so
+// that this method never needs a lock of its own: the stock delta is
+// applied with a single atomic UPDATE, and the CHECK (current_stock >= 0)
+// constraint on products rejects any update that would oversell a SKU.
+// When offset is non-nil, the consumed position is stored in the same
+// transaction as the inventory update (see DeliverySemantics.ExactlyOnce),
+// so a crash can never commit one without the other.
+func (ic *InventoryConsumer) UpdateInventory(ctx context.Context, event InventoryEvent, offset *OffsetInfo) error {
+	var offsetTopic string
+	var offsetPartition int32
+	var offsetOffset int64
+	if offset != nil {
+		offsetTopic, offsetPartition, offsetOffset = offset.Topic, offset.Partition, offset.Offset
+	}
 
-	// BUG: Transaction not using proper isolation level
-	tx, err := ic.db.BeginTx(ctx, nil)
+	_, skipped, err := ic.store.ApplyUpdate(ctx, event.EventID, event.ProductID, event.QuantityChange,
+		offset != nil, offsetTopic, offsetPartition, offsetOffset)
 	if err != nil {
-		return fmt.Errorf("failed to begin transaction: %w", err)
-	}
-	defer tx.Rollback()
-
-	// BUG: Check for duplicate events without proper row-level locking
-	var processed bool
-	err = tx.QueryRowContext(ctx,
-		"SELECT COUNT(*) > 0 FROM processed_events WHERE event_id = $1",
-		event.EventID).Scan(&processed)
-	if err != nil && err != sql.ErrNoRows {
 		return err
 	}
-
-	if processed {
-		// Event already processed - but no atomic commit guarantee
+	if skipped {
+		// Event already processed - nothing left to do.
 		return nil
 	}
 
-	// Step 1: Read current stock (RACE CONDITION: Not locked in database)
-	var currentStock int
-	err = tx.QueryRowContext(ctx,
-		"SELECT current_stock FROM products WHERE sku = $1",
-		event.ProductID).Scan(&currentStock)
-	if err != nil && err != sql.ErrNoRows {
-		return fmt.Errorf("failed to read stock: %w", err)
-	}
-
-	newStock := currentStock + event.QuantityChange
-
-	// BUG: No check for negative stock or overselling
-	if newStock < 0 {
-		log.Printf("WARNING: Negative stock detected for %s: %d", event.ProductID, newStock)
-	}
-
-	// Step 2: Update stock (LOST UPDATE RACE: Another goroutine might have updated between read and write)
-	_, err = tx.ExecContext(ctx,
-		`INSERT INTO products (sku, current_stock, version)
-		 VALUES ($1, $2, 1)
-		 ON CONFLICT (sku) DO UPDATE SET
-		 current_stock = products.current_stock + $3,
-		 version = products.version + 1`,
-		event.ProductID, newStock, event.QuantityChange)
-	if err != nil {
-		return fmt.Errorf("failed to update stock: %w", err)
-	}
-
-	// BUG: Event marked as processed BEFORE Kafka offset commit
-	// If we crash here, we'll reprocess the message but have already marked it
-	_, err = tx.ExecContext(ctx,
-		"INSERT INTO processed_events (event_id, product_id) VALUES ($1, $2)",
-		event.EventID, event.ProductID)
-	if err != nil {
-		return fmt.Errorf("failed to mark event processed: %w", err)
-	}
-
-	// BUG: Commit happens before offset commit - potential message loss
-	err = tx.Commit()
-	if err != nil {
-		return fmt.Errorf("failed to commit transaction: %w", err)
-	}
-
 	atomic.AddInt64(&ic.metrics.messagesProcessed, 1)
 	return nil
 }
 
-// ConsumeMessages starts consuming messages from Kafka
-func (ic *InventoryConsumer) ConsumeMessages(ctx context.Context, topicName string, numWorkers int) error {
-	err := ic.consumer.SubscribeTopics([]string{topicName}, nil)
+// ConsumeMessages starts consuming messages from Kafka and fans them out
+// across ic.workerShards goroutines, one per shard, so that updates to the
+// same SKU are always handled by the same goroutine (strict per-key
+// ordering, matching Kafka's per-partition guarantee) while unrelated SKUs
+// run concurrently. semantics controls whether offsets are committed to
+// Kafka after the fact (AtLeastOnce) or stored in Postgres as part of the
+// same transaction as the inventory update (ExactlyOnce).
+func (ic *InventoryConsumer) ConsumeMessages(ctx context.Context, topicName string, semantics DeliverySemantics) error {
+	var rebalanceCb kafka.RebalanceCb
+	if semantics == ExactlyOnce {
+		rebalanceCb = ic.rebalanceCallback
+	}
+
+	err := ic.consumer.SubscribeTopics([]string{topicName}, rebalanceCb)
 	if err != nil {
 		return fmt.Errorf("failed to subscribe: %w", err)
 	}
 
-	// BUG: Using unbounded goroutines without proper worker pool limits
-	// This can cause goroutine explosion and make the race condition worse
-	msgChan := make(chan *kafka.Message, 100)
 	var wg sync.WaitGroup
+	// OutboxRelay blocks until ctx is cancelled and its own relay workers
+	// have all returned, but nothing previously waited for it: tracking it
+	// in wg too is what lets ConsumeMessages guarantee it won't return to a
+	// caller (e.g. main, which closes ic.db right after) while the relay is
+	// still mid-poll against ic.store.
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ic.OutboxRelay(ctx)
+	}()
 
-	// Start worker goroutines - but they all share the global lock!
-	for i := 0; i < numWorkers; i++ {
+	shardChans := make([]chan shardedMessage, ic.workerShards)
+	for i := range shardChans {
+		shardChans[i] = make(chan shardedMessage, 100)
 		wg.Add(1)
-		go ic.messageWorker(ctx, &wg, msgChan)
+		go ic.messageWorker(ctx, &wg, shardChans[i], semantics)
 	}
 
-	// Main consumer loop
+	// Main consumer loop: parse each message once, then route it to the
+	// shard that owns its ProductID. wg tracks this goroutine too, so
+	// ConsumeMessages can't return (and the caller can't assume every
+	// goroutine it started has exited) while this loop is still unwinding.
+	wg.Add(1)
 	go func() {
+		defer wg.Done()
 		for {
 			select {
 			case <-ctx.Done():
 				ic.consumer.Close()
-				close(msgChan)
+				for _, ch := range shardChans {
+					close(ch)
+				}
 				return
 			default:
 				msg, err := ic.consumer.ReadMessage(100 * 1000) // 100ms timeout
@@ -213,7 +269,23 @@ func (ic *InventoryConsumer) ConsumeMessages(ctx context.Context, topicName stri
 					}
 					continue
 				}
-				msgChan <- msg
+
+				event := InventoryEvent{
+					ProductID:      string(msg.Headers[0].Value),
+					QuantityChange: int(msg.Value[0]), // Simplified parsing - BUG in real code
+					EventID:        string(msg.Key),
+				}
+				shard := shardFor(event.ProductID, ic.workerShards)
+				// A worker may have already exited after observing ctx.Done
+				// (see messageWorker), in which case this send would block
+				// forever against a channel nobody is draining anymore;
+				// racing it against ctx.Done() here instead of blocking
+				// unconditionally is what lets this goroutine reach the shard
+				// channel close in the ctx.Done case above instead of leaking.
+				select {
+				case shardChans[shard] <- shardedMessage{msg: msg, event: event}:
+				case <-ctx.Done():
+				}
 			}
 		}
 	}()
@@ -222,46 +294,55 @@ func (ic *InventoryConsumer) ConsumeMessages(ctx context.Context, topicName stri
 	return nil
 }
 
-// messageWorker processes messages from the channel
-func (ic *InventoryConsumer) messageWorker(ctx context.Context, wg *sync.WaitGroup, msgChan chan *kafka.Message) {
+// messageWorker processes messages for a single shard, in order. A message
+// that exhausts its retries (or fails permanently) is routed to the DLQ by
+// processWithRetry rather than dropped, so it never blocks the shard. If ctx
+// is cancelled mid-retry, processWithRetry returns ctx.Err() without having
+// applied the event or dead-lettered it; the worker stops immediately rather
+// than committing, so the message is never partially processed -- it will
+// be read and retried again from scratch after a restart. Any other error
+// (e.g. the DLQ producer itself failing) is logged and skipped rather than
+// exiting the loop: this goroutine is the only consumer of shardChan, and
+// the router keeps routing same-SKU traffic here for the life of the
+// process, so returning early would wedge every future message on this
+// shard instead of just dropping the one that couldn't be dead-lettered.
+func (ic *InventoryConsumer) messageWorker(ctx context.Context, wg *sync.WaitGroup, shardChan chan shardedMessage, semantics DeliverySemantics) {
 	defer wg.Done()
 
-	for msg := range msgChan {
-		// Parse message value as JSON
-		event := InventoryEvent{
-			ProductID:      string(msg.Headers[0].Value),
-			QuantityChange: int(msg.Value[0]), // Simplified parsing - BUG in real code
-			EventID:        string(msg.Key),
+	for sm := range shardChan {
+		msg, event := sm.msg, sm.event
+
+		var offset *OffsetInfo
+		if semantics == ExactlyOnce {
+			// The offset advances inside the same transaction as the
+			// inventory update (or via AdvanceOffset if it's dead-lettered),
+			// so there's nothing left to commit to Kafka.
+			offset = &OffsetInfo{
+				Topic:     *msg.TopicPartition.Topic,
+				Partition: msg.TopicPartition.Partition,
+				Offset:    int64(msg.TopicPartition.Offset),
+			}
 		}
 
-		// BUG: No timeout or context propagation
-		err := ic.UpdateInventory(ctx, event)
-		if err != nil {
-			log.Printf("Error updating inventory: %v", err)
-			// BUG: No retry logic or dead letter queue
+		if err := ic.processWithRetry(ctx, msg, event, offset); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("Failed to process message (event %s): %v", event.EventID, err)
+			continue
 		}
 
-		// BUG: Offset commit happens AFTER processing, not atomically with database write
-		// If service crashes, we lose the offset but have updated the database
-		_, err = ic.consumer.CommitMessage(msg)
-		if err != nil {
-			log.Printf("Failed to commit offset: %v", err)
+		if semantics == AtLeastOnce {
+			if _, err := ic.consumer.CommitMessage(msg); err != nil {
+				log.Printf("Failed to commit offset: %v", err)
+			}
 		}
 	}
 }
 
-// GetInventory retrieves the current inventory for a product
-// BUG: This read operation doesn't use read lock, can read while Write is happening
+// GetInventory retrieves the current inventory for a product.
 func (ic *InventoryConsumer) GetInventory(ctx context.Context, sku string) (int, error) {
-	ic.inventoryLock.Lock() // Should be RLock for read operations
-	defer ic.inventoryLock.Unlock()
-
-	var stock int
-	err := ic.db.QueryRowContext(ctx, "SELECT current_stock FROM products WHERE sku = $1", sku).Scan(&stock)
-	if err != nil {
-		return 0, err
-	}
-	return stock, nil
+	return ic.store.GetStock(ctx, sku)
 }
 
 // GetMetrics returns current metrics
@@ -289,7 +370,7 @@ func main() {
 	}
 
 	// Create consumer
-	consumer, err := NewInventoryConsumer(brokers, groupID)
+	consumer, err := NewInventoryConsumer(brokers, groupID, WithWorkerShards(DefaultWorkerShards))
 	if err != nil {
 		log.Fatalf("Failed to create consumer: %v", err)
 	}
@@ -317,8 +398,7 @@ func main() {
 	}()
 
 	// Start consuming messages
-	numWorkers := 4
-	if err := consumer.ConsumeMessages(ctx, "inventory-events", numWorkers); err != nil {
+	if err := consumer.ConsumeMessages(ctx, "inventory-events", ExactlyOnce); err != nil {
 		log.Fatalf("Error consuming messages: %v", err)
 	}
 
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+// DeliverySemantics selects how ConsumeMessages reconciles Kafka offsets
+// with the database transaction that applies each event.
+type DeliverySemantics int
+
+const (
+	// AtLeastOnce commits offsets back to Kafka after the DB transaction
+	// succeeds. A crash between the two can redeliver a message.
+	AtLeastOnce DeliverySemantics = iota
+	// ExactlyOnce stores offsets in the same Postgres transaction as the
+	// inventory update, so the database becomes the source of truth for
+	// consumer progress instead of Kafka's committed offsets.
+	ExactlyOnce
+)
+
+// OffsetInfo identifies the Kafka position a message was read from.
+type OffsetInfo struct {
+	Topic     string
+	Partition int32
+	Offset    int64
+}
+
+// seekTargets computes, for each partition in assigned, the offset to hand
+// to Consumer.Assign: max(stored_offset)+1 if Postgres has a recorded
+// offset for that partition, or kafka.OffsetBeginning otherwise. This is
+// all of rebalanceCallback's "resume where the database left off" logic,
+// kept free of *kafka.Consumer so it can be unit tested directly --
+// kafka.RebalanceCb's signature requires a real *kafka.Consumer to call
+// Assign/Unassign on, which the tester package's FakeSource cannot safely
+// fabricate.
+func seekTargets(stored map[int32]int64, assigned []kafka.TopicPartition) []kafka.TopicPartition {
+	out := make([]kafka.TopicPartition, len(assigned))
+	for i, tp := range assigned {
+		if off, ok := stored[tp.Partition]; ok {
+			tp.Offset = kafka.Offset(off + 1)
+		} else {
+			tp.Offset = kafka.OffsetBeginning
+		}
+		out[i] = tp
+	}
+	return out
+}
+
+// rebalanceCallback is registered with SubscribeTopics in ExactlyOnce mode.
+// On assignment it seeks every partition to max(stored_offset)+1 from
+// Postgres rather than trusting Kafka's committed offsets, so a worker that
+// crashed between a DB commit and an offset commit resumes exactly where
+// the database last recorded progress. See seekTargets for the offset
+// computation itself.
+func (ic *InventoryConsumer) rebalanceCallback(c *kafka.Consumer, ev kafka.Event) error {
+	switch e := ev.(type) {
+	case kafka.AssignedPartitions:
+		if len(e.Partitions) == 0 {
+			return nil
+		}
+
+		stored, err := ic.store.LoadStoredOffsets(context.Background(), *e.Partitions[0].Topic)
+		if err != nil {
+			return fmt.Errorf("failed to load stored offsets for rebalance: %w", err)
+		}
+
+		return c.Assign(seekTargets(stored, e.Partitions))
+
+	case kafka.RevokedPartitions:
+		return c.Unassign()
+	}
+	return nil
+}
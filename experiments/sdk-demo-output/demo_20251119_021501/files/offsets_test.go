@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+
+	"inventory-consumer/tester"
+)
+
+// TestSeekTargetsResumesAtStoredOffsetPlusOne exercises the actual
+// "resume at max(stored_offset)+1" logic rebalanceCallback hands to
+// Consumer.Assign on partition assignment, which is the guarantee
+// ExactlyOnce delivery depends on: a partition with a recorded offset
+// resumes just past it, one without resumes from the beginning.
+func TestSeekTargetsResumesAtStoredOffsetPlusOne(t *testing.T) {
+	topic := "inventory-events"
+	var p0, p1 int32 = 0, 1
+	stored := map[int32]int64{0: 41} // partition 0 last recorded at offset 41
+
+	assigned := []kafka.TopicPartition{
+		{Topic: &topic, Partition: p0},
+		{Topic: &topic, Partition: p1},
+	}
+
+	got := seekTargets(stored, assigned)
+
+	if got[0].Offset != kafka.Offset(42) {
+		t.Errorf("partition 0 offset = %v, want 42 (stored 41 + 1)", got[0].Offset)
+	}
+	if got[1].Offset != kafka.OffsetBeginning {
+		t.Errorf("partition 1 offset = %v, want OffsetBeginning (no stored offset)", got[1].Offset)
+	}
+}
+
+// TestRebalanceSeeksFromStoreNotKafka asserts the offsets seekTargets acts
+// on come from InventoryStore.LoadStoredOffsets -- the database, not
+// Kafka's committed offsets -- which is what lets a worker that crashed
+// between a DB commit and an offset commit resume exactly where the
+// database last recorded progress.
+func TestRebalanceSeeksFromStoreNotKafka(t *testing.T) {
+	_, store := tester.New(t)
+	topic := "inventory-events"
+
+	if err := store.AdvanceOffset(context.Background(), topic, 0, 41); err != nil {
+		t.Fatalf("AdvanceOffset failed: %v", err)
+	}
+
+	stored, err := store.LoadStoredOffsets(context.Background(), topic)
+	if err != nil {
+		t.Fatalf("LoadStoredOffsets failed: %v", err)
+	}
+
+	var p0, p1 int32 = 0, 1
+	got := seekTargets(stored, []kafka.TopicPartition{
+		{Topic: &topic, Partition: p0},
+		{Topic: &topic, Partition: p1},
+	})
+
+	if got[0].Offset != kafka.Offset(42) {
+		t.Errorf("partition 0 offset = %v, want 42", got[0].Offset)
+	}
+	if got[1].Offset != kafka.OffsetBeginning {
+		t.Errorf("partition 1 offset = %v, want OffsetBeginning", got[1].Offset)
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+// OutboxConfig controls how OutboxRelay polls inventory_outbox and publishes
+// rows to Topic.
+type OutboxConfig struct {
+	Topic        string
+	PollInterval time.Duration
+	BatchSize    int
+	Parallelism  int
+}
+
+// DefaultOutboxConfig is used when NewInventoryConsumer is not given
+// WithOutboxConfig.
+var DefaultOutboxConfig = OutboxConfig{
+	Topic:        "inventory-changes",
+	PollInterval: time.Second,
+	BatchSize:    100,
+	Parallelism:  1,
+}
+
+// WithOutboxConfig overrides the outbox relay's topic, poll interval, batch
+// size, and worker count.
+func WithOutboxConfig(cfg OutboxConfig) ConsumerOption {
+	return func(ic *InventoryConsumer) {
+		ic.outboxConfig = cfg
+	}
+}
+
+// WithOutboxProducer overrides the producer OutboxRelay publishes to,
+// mainly so tests can substitute an in-memory fake.
+func WithOutboxProducer(p OutboxProducer) ConsumerOption {
+	return func(ic *InventoryConsumer) {
+		ic.outboxProducer = p
+	}
+}
+
+// OutboxProducer abstracts the subset of *kafka.Producer OutboxRelay depends
+// on, so tests can substitute an in-memory fake for it.
+type OutboxProducer interface {
+	Produce(msg *kafka.Message, deliveryChan chan kafka.Event) error
+}
+
+// outboxLagGauge is the unpublished row count observed on the relay's most
+// recent poll, exposed via OutboxLag so operators can alert on a growing
+// backlog.
+var outboxLagGauge int64
+
+// OutboxLag returns the outbox backlog observed on the most recent poll.
+func OutboxLag() int64 {
+	return atomic.LoadInt64(&outboxLagGauge)
+}
+
+// OutboxRelay polls inventory_outbox for unpublished rows and publishes them
+// to ic.outboxConfig.Topic, running ic.outboxConfig.Parallelism workers
+// concurrently so independent batches can be claimed and published at once.
+// It returns once ctx is cancelled.
+func (ic *InventoryConsumer) OutboxRelay(ctx context.Context) {
+	parallelism := ic.outboxConfig.Parallelism
+	if parallelism < 1 {
+		parallelism = 1
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < parallelism; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ic.relayLoop(ctx)
+		}()
+	}
+	wg.Wait()
+}
+
+func (ic *InventoryConsumer) relayLoop(ctx context.Context) {
+	ticker := time.NewTicker(ic.outboxConfig.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			ic.relayBatch(ctx)
+		}
+	}
+}
+
+// relayBatch claims up to ic.outboxConfig.BatchSize unpublished rows and
+// publishes each to ic.outboxConfig.Topic. A row is only marked published
+// (and its claiming transaction committed) once the producer acknowledges
+// it, so a publish failure leaves the row to be retried on the next poll.
+func (ic *InventoryConsumer) relayBatch(ctx context.Context) {
+	_, err := ic.store.RelayOutboxBatch(ctx, ic.outboxConfig.BatchSize, func(ids []int64, skus []string, deltas []int, newStocks []int) error {
+		topic := ic.outboxConfig.Topic
+		for i, id := range ids {
+			msg := &kafka.Message{
+				TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: kafka.PartitionAny},
+				Key:            []byte(skus[i]),
+				Value:          []byte(fmt.Sprintf(`{"sku":%q,"delta":%d,"new_stock":%d}`, skus[i], deltas[i], newStocks[i])),
+			}
+			deliveryChan := make(chan kafka.Event, 1)
+			if err := ic.outboxProducer.Produce(msg, deliveryChan); err != nil {
+				return fmt.Errorf("failed to publish outbox row %d: %w", id, err)
+			}
+			select {
+			case ev := <-deliveryChan:
+				if delivered, ok := ev.(*kafka.Message); ok && delivered.TopicPartition.Error != nil {
+					return fmt.Errorf("delivery failed for outbox row %d: %w", id, delivered.TopicPartition.Error)
+				}
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Outbox relay batch failed: %v", err)
+	}
+
+	remaining, err := ic.store.CountUnpublishedOutbox(ctx)
+	if err != nil {
+		log.Printf("Failed to measure outbox lag: %v", err)
+		return
+	}
+	atomic.StoreInt64(&outboxLagGauge, int64(remaining))
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"inventory-consumer/tester"
+)
+
+// TestOutboxRelayPublishesAppliedUpdates applies an inventory update, then
+// drives a single relay poll directly and asserts the resulting outbox row
+// is published to the configured topic exactly once, with the CountUnpublishedOutbox-backed
+// OutboxLag metric reflecting the drained backlog afterward.
+func TestOutboxRelayPublishesAppliedUpdates(t *testing.T) {
+	_, store := tester.New(t)
+	outboxProducer := tester.NewFakeDLQProducer()
+	consumer := &InventoryConsumer{
+		store:        store,
+		metrics:      &Metrics{},
+		workerShards: 1,
+		retryConfig:  DefaultRetryConfig,
+		outboxConfig: OutboxConfig{
+			Topic:        "inventory-changes",
+			PollInterval: time.Millisecond,
+			BatchSize:    10,
+			Parallelism:  1,
+		},
+		outboxProducer: outboxProducer,
+	}
+
+	event := InventoryEvent{ProductID: "SKU001", QuantityChange: 5, EventID: "evt-1"}
+	if err := consumer.UpdateInventory(context.Background(), event, nil); err != nil {
+		t.Fatalf("UpdateInventory failed: %v", err)
+	}
+
+	consumer.relayBatch(context.Background())
+
+	msgs := outboxProducer.Messages()
+	if len(msgs) != 1 {
+		t.Fatalf("expected 1 outbox message, got %d", len(msgs))
+	}
+	if got, want := string(msgs[0].Key), event.ProductID; got != want {
+		t.Errorf("outbox message key = %q, want %q", got, want)
+	}
+	if got, want := *msgs[0].TopicPartition.Topic, consumer.outboxConfig.Topic; got != want {
+		t.Errorf("outbox message topic = %q, want %q", got, want)
+	}
+	if got := OutboxLag(); got != 0 {
+		t.Errorf("OutboxLag() = %d, want 0 after the only row was relayed", got)
+	}
+
+	// A second poll with nothing new queued should be a no-op.
+	consumer.relayBatch(context.Background())
+	if got := len(outboxProducer.Messages()); got != 1 {
+		t.Errorf("expected relay to still show only 1 published message, got %d", got)
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math/rand"
+	"strconv"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/lib/pq"
+)
+
+// RetryConfig controls how messageWorker retries a failed UpdateInventory
+// call before giving up and routing the message to DLQTopic.
+type RetryConfig struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	DLQTopic       string
+}
+
+// DefaultRetryConfig is used when NewInventoryConsumer is not given
+// WithRetryConfig.
+var DefaultRetryConfig = RetryConfig{
+	MaxAttempts:    5,
+	InitialBackoff: 100 * time.Millisecond,
+	MaxBackoff:     10 * time.Second,
+	DLQTopic:       "inventory-events-dlq",
+}
+
+// WithRetryConfig overrides the retry/backoff/DLQ behavior of ConsumeMessages.
+func WithRetryConfig(cfg RetryConfig) ConsumerOption {
+	return func(ic *InventoryConsumer) {
+		ic.retryConfig = cfg
+	}
+}
+
+// WithDLQProducer overrides the producer messages are dead-lettered to,
+// mainly so tests can substitute an in-memory fake.
+func WithDLQProducer(p DLQProducer) ConsumerOption {
+	return func(ic *InventoryConsumer) {
+		ic.dlqProducer = p
+	}
+}
+
+// DLQProducer abstracts the subset of *kafka.Producer the retry subsystem
+// depends on, so tests can substitute an in-memory fake for it.
+type DLQProducer interface {
+	Produce(msg *kafka.Message, deliveryChan chan kafka.Event) error
+}
+
+// isRetryable classifies an UpdateInventory error as transient (worth
+// retrying) or permanent (should go straight to the DLQ). Transient DB
+// errors -- serialization failures, deadlocks, and connection drops -- are
+// identified via their Postgres SQLSTATE; anything else, including the
+// CHECK (current_stock >= 0) violation a negative-stock update raises and
+// malformed-message errors, is treated as permanent.
+func isRetryable(err error) bool {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return false
+	}
+	switch pqErr.Code {
+	case "40001", // serialization_failure
+		"40P01", // deadlock_detected
+		"08000", // connection_exception
+		"08006": // connection_failure
+		return true
+	}
+	return false
+}
+
+// backoffWithJitter returns the delay before retry attempt (0-indexed),
+// doubling cfg.InitialBackoff each attempt up to cfg.MaxBackoff and adding
+// up to 20% jitter so retrying workers don't all wake up in lockstep.
+func backoffWithJitter(cfg RetryConfig, attempt int) time.Duration {
+	delay := cfg.InitialBackoff << attempt
+	if delay <= 0 || delay > cfg.MaxBackoff {
+		delay = cfg.MaxBackoff
+	}
+	jitter := time.Duration(rand.Int63n(int64(delay)/5 + 1))
+	return delay + jitter
+}
+
+// processWithRetry calls UpdateInventory up to ic.retryConfig.MaxAttempts
+// times, backing off between transient failures. A permanent error, or a
+// transient one that never succeeds, routes the message to the DLQ topic
+// instead of being dropped silently. If ctx is cancelled before the message
+// reaches either outcome, processWithRetry returns ctx.Err() immediately
+// without dead-lettering or advancing the offset, leaving the message to be
+// read and retried from scratch after a restart.
+func (ic *InventoryConsumer) processWithRetry(ctx context.Context, msg *kafka.Message, event InventoryEvent, offset *OffsetInfo) error {
+	var lastErr error
+	attempts := 0
+	for attempts < ic.retryConfig.MaxAttempts {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		attempts++
+		lastErr = ic.UpdateInventory(ctx, event, offset)
+		if lastErr == nil {
+			return nil
+		}
+		if errors.Is(lastErr, context.Canceled) || errors.Is(lastErr, context.DeadlineExceeded) {
+			return lastErr
+		}
+		if !isRetryable(lastErr) || attempts == ic.retryConfig.MaxAttempts {
+			break
+		}
+
+		select {
+		case <-time.After(backoffWithJitter(ic.retryConfig, attempts-1)):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	if err := ic.sendToDLQ(msg, lastErr, attempts); err != nil {
+		log.Printf("Failed to send message to DLQ: %v", err)
+		return err
+	}
+
+	// The event was never applied, so its offset must still be advanced
+	// past here or an ExactlyOnce consumer would replay (and re-DLQ) it
+	// forever after a restart.
+	if offset != nil {
+		if err := ic.store.AdvanceOffset(ctx, offset.Topic, offset.Partition, offset.Offset); err != nil {
+			log.Printf("Failed to advance offset past dead-lettered message: %v", err)
+		}
+	}
+	return nil
+}
+
+// sendToDLQ produces msg to the configured DLQ topic, preserving its
+// original key and recording why it was dead-lettered.
+func (ic *InventoryConsumer) sendToDLQ(msg *kafka.Message, cause error, attempts int) error {
+	topic := ic.retryConfig.DLQTopic
+	dlqMsg := &kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: kafka.PartitionAny},
+		Key:            msg.Key,
+		Value:          msg.Value,
+		Headers: []kafka.Header{
+			{Key: "x-original-topic", Value: []byte(*msg.TopicPartition.Topic)},
+			{Key: "x-error", Value: []byte(cause.Error())},
+			{Key: "x-attempt-count", Value: []byte(strconv.Itoa(attempts))},
+		},
+	}
+	return ic.dlqProducer.Produce(dlqMsg, nil)
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/lib/pq"
+
+	"inventory-consumer/tester"
+)
+
+// flakyStore wraps an InventoryStore and fails the first failuresLeft calls
+// to ApplyUpdate with a serialization_failure error, so the retry path can
+// be exercised without a real Postgres instance.
+type flakyStore struct {
+	InventoryStore
+	failuresLeft int64
+}
+
+func (s *flakyStore) ApplyUpdate(ctx context.Context, eventID, sku string, delta int, hasOffset bool, offsetTopic string, offsetPartition int32, offsetOffset int64) (int, bool, error) {
+	if atomic.AddInt64(&s.failuresLeft, -1) >= 0 {
+		return 0, false, &pq.Error{Code: "40001", Message: "could not serialize access due to concurrent update"}
+	}
+	return s.InventoryStore.ApplyUpdate(ctx, eventID, sku, delta, hasOffset, offsetTopic, offsetPartition, offsetOffset)
+}
+
+func headerValue(msg *kafka.Message, key string) string {
+	for _, h := range msg.Headers {
+		if h.Key == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+// failingDLQProducer always fails to produce, simulating a DLQ producer
+// whose send queue is full or whose broker is unreachable.
+type failingDLQProducer struct{}
+
+func (failingDLQProducer) Produce(msg *kafka.Message, deliveryChan chan kafka.Event) error {
+	return fmt.Errorf("dlq producer unavailable")
+}
+
+// TestRetrySucceedsAfterSerializationFailures asserts that a transient
+// serialization failure is retried rather than immediately dead-lettered,
+// and that the event is applied once it stops failing.
+func TestRetrySucceedsAfterSerializationFailures(t *testing.T) {
+	src, store := tester.New(t)
+	dlq := tester.NewFakeDLQProducer()
+	consumer := &InventoryConsumer{
+		consumer:     src,
+		store:        &flakyStore{InventoryStore: store, failuresLeft: 2},
+		metrics:      &Metrics{},
+		workerShards: 1,
+		dlqProducer:  dlq,
+		retryConfig: RetryConfig{
+			MaxAttempts:    5,
+			InitialBackoff: time.Millisecond,
+			MaxBackoff:     5 * time.Millisecond,
+			DLQTopic:       "inventory-events-dlq",
+		},
+		outboxConfig:   DefaultOutboxConfig,
+		outboxProducer: tester.NewFakeDLQProducer(),
+	}
+
+	event := InventoryEvent{ProductID: "SKU001", QuantityChange: 5, EventID: "evt-retry"}
+	pushEvent(src, "inventory-events", 0, event)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	if err := consumer.ConsumeMessages(ctx, "inventory-events", AtLeastOnce); err != nil {
+		t.Fatalf("ConsumeMessages failed: %v", err)
+	}
+
+	if got, want := store.Stock("SKU001"), 5; got != want {
+		t.Errorf("stock = %d, want %d (update should succeed once retries exhaust the transient failures)", got, want)
+	}
+	if got := dlq.Len(); got != 0 {
+		t.Errorf("expected no DLQ deliveries, got %d", got)
+	}
+}
+
+// TestRetryExhaustionSendsToDLQ asserts that a serialization failure which
+// never clears is retried up to MaxAttempts and then dead-lettered, with
+// headers identifying the original topic, the failure, and the attempt
+// count.
+func TestRetryExhaustionSendsToDLQ(t *testing.T) {
+	src, store := tester.New(t)
+	dlq := tester.NewFakeDLQProducer()
+	consumer := &InventoryConsumer{
+		consumer:     src,
+		store:        &flakyStore{InventoryStore: store, failuresLeft: 1 << 20}, // always fails
+		metrics:      &Metrics{},
+		workerShards: 1,
+		dlqProducer:  dlq,
+		retryConfig: RetryConfig{
+			MaxAttempts:    3,
+			InitialBackoff: time.Millisecond,
+			MaxBackoff:     5 * time.Millisecond,
+			DLQTopic:       "inventory-events-dlq",
+		},
+		outboxConfig:   DefaultOutboxConfig,
+		outboxProducer: tester.NewFakeDLQProducer(),
+	}
+
+	event := InventoryEvent{ProductID: "SKU001", QuantityChange: 5, EventID: "evt-dlq"}
+	pushEvent(src, "inventory-events", 0, event)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	if err := consumer.ConsumeMessages(ctx, "inventory-events", AtLeastOnce); err != nil {
+		t.Fatalf("ConsumeMessages failed: %v", err)
+	}
+
+	msgs := dlq.Messages()
+	if len(msgs) != 1 {
+		t.Fatalf("expected exactly 1 DLQ delivery, got %d", len(msgs))
+	}
+	if got, want := string(msgs[0].Key), event.EventID; got != want {
+		t.Errorf("DLQ message key = %q, want %q", got, want)
+	}
+	if got, want := headerValue(msgs[0], "x-original-topic"), "inventory-events"; got != want {
+		t.Errorf("x-original-topic = %q, want %q", got, want)
+	}
+	if got, want := headerValue(msgs[0], "x-attempt-count"), "3"; got != want {
+		t.Errorf("x-attempt-count = %q, want %q", got, want)
+	}
+	if headerValue(msgs[0], "x-error") == "" {
+		t.Error("x-error header should record why the message was dead-lettered")
+	}
+	if store.Processed(event.EventID) {
+		t.Error("a message that lands in the DLQ should not be marked processed")
+	}
+}
+
+// TestShardSurvivesDLQProducerFailure asserts that when sendToDLQ itself
+// fails (e.g. the DLQ producer's send queue is full), messageWorker logs and
+// skips the message instead of exiting -- the router keeps routing same-SKU
+// traffic to this shard's channel for the life of the process, so returning
+// early would silently wedge every later message on the shard, not just the
+// one that couldn't be dead-lettered.
+func TestShardSurvivesDLQProducerFailure(t *testing.T) {
+	src, store := tester.New(t)
+	consumer := &InventoryConsumer{
+		consumer:     src,
+		store:        &flakyStore{InventoryStore: store, failuresLeft: 2}, // fails just evt-1's attempts
+		metrics:      &Metrics{},
+		workerShards: 1,
+		dlqProducer:  failingDLQProducer{},
+		retryConfig: RetryConfig{
+			MaxAttempts:    2,
+			InitialBackoff: time.Millisecond,
+			MaxBackoff:     5 * time.Millisecond,
+			DLQTopic:       "inventory-events-dlq",
+		},
+		outboxConfig:   DefaultOutboxConfig,
+		outboxProducer: tester.NewFakeDLQProducer(),
+	}
+
+	// evt-1 exhausts its retries and fails to dead-letter; evt-2 is queued
+	// right behind it on the same shard (workerShards: 1) and must still be
+	// applied.
+	pushEvent(src, "inventory-events", 0, InventoryEvent{ProductID: "SKU001", QuantityChange: 5, EventID: "evt-1"})
+	pushEvent(src, "inventory-events", 0, InventoryEvent{ProductID: "SKU002", QuantityChange: 5, EventID: "evt-2"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	if err := consumer.ConsumeMessages(ctx, "inventory-events", AtLeastOnce); err != nil {
+		t.Fatalf("ConsumeMessages failed: %v", err)
+	}
+
+	if got, want := store.Stock("SKU002"), 5; got != want {
+		t.Errorf("SKU002 stock = %d, want %d -- shard should keep processing after a failed DLQ produce", got, want)
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"hash/fnv"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+// DefaultWorkerShards is used when NewInventoryConsumer is constructed
+// without WithWorkerShards.
+const DefaultWorkerShards = 8
+
+// ConsumerOption configures an InventoryConsumer at construction time.
+type ConsumerOption func(*InventoryConsumer)
+
+// WithWorkerShards sets the number of per-key worker goroutines
+// ConsumeMessages fans events out to. Every message for a given ProductID
+// is routed to the same shard, so updates to that SKU are processed in
+// order relative to each other while unrelated SKUs run in parallel.
+func WithWorkerShards(n int) ConsumerOption {
+	return func(ic *InventoryConsumer) {
+		ic.workerShards = n
+	}
+}
+
+// shardFor hashes productID to one of shards worker goroutines.
+func shardFor(productID string, shards int) int {
+	h := fnv.New32a()
+	h.Write([]byte(productID))
+	return int(h.Sum32() % uint32(shards))
+}
+
+// shardedMessage pairs a raw Kafka message with its parsed event so the
+// dispatch loop only decodes each message once before routing it to a shard.
+type shardedMessage struct {
+	msg   *kafka.Message
+	event InventoryEvent
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+// pgStore is the Postgres-backed InventoryStore used in production.
+type pgStore struct {
+	db *sql.DB
+}
+
+// NewPostgresStore wraps db as an InventoryStore.
+func NewPostgresStore(db *sql.DB) InventoryStore {
+	return &pgStore{db: db}
+}
+
+// ApplyUpdate runs the idempotency check, stock update, processed-event
+// marker, and (optionally) the offset upsert inside a single transaction.
+func (s *pgStore) ApplyUpdate(ctx context.Context, eventID, sku string, delta int, hasOffset bool, offsetTopic string, offsetPartition int32, offsetOffset int64) (int, bool, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var processed bool
+	err = tx.QueryRowContext(ctx,
+		"SELECT COUNT(*) > 0 FROM processed_events WHERE event_id = $1",
+		eventID).Scan(&processed)
+	if err != nil && err != sql.ErrNoRows {
+		return 0, false, err
+	}
+	if processed {
+		return 0, true, nil
+	}
+
+	// Apply the delta atomically in one statement instead of reading the
+	// stock and writing it back: the database computes current_stock + delta
+	// itself, so there is no read-modify-write window for a concurrent
+	// update to race with. The CHECK (current_stock >= 0) constraint on
+	// products rejects any update that would oversell sku.
+	var newStock int
+	err = tx.QueryRowContext(ctx,
+		`INSERT INTO products (sku, current_stock, version)
+		 VALUES ($1, $2, 1)
+		 ON CONFLICT (sku) DO UPDATE SET
+		 current_stock = products.current_stock + $2,
+		 version = products.version + 1
+		 RETURNING current_stock`,
+		sku, delta).Scan(&newStock)
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to update stock: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		"INSERT INTO processed_events (event_id, product_id) VALUES ($1, $2)",
+		eventID, sku); err != nil {
+		return 0, false, fmt.Errorf("failed to mark event processed: %w", err)
+	}
+
+	// Recording the resulting delta/level in the same transaction gives
+	// OutboxRelay an exactly-once view of every stock change to republish
+	// downstream, without a second write path that could fall out of sync.
+	if _, err := tx.ExecContext(ctx,
+		"INSERT INTO inventory_outbox (sku, delta, new_stock) VALUES ($1, $2, $3)",
+		sku, delta, newStock); err != nil {
+		return 0, false, fmt.Errorf("failed to write outbox row: %w", err)
+	}
+
+	if hasOffset {
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO consumer_offsets (topic, partition, "offset")
+			 VALUES ($1, $2, $3)
+			 ON CONFLICT (topic, partition) DO UPDATE SET
+			 "offset" = EXCLUDED."offset"`,
+			offsetTopic, offsetPartition, offsetOffset); err != nil {
+			return 0, false, fmt.Errorf("failed to store offset: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, false, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return newStock, false, nil
+}
+
+func (s *pgStore) GetStock(ctx context.Context, sku string) (int, error) {
+	var stock int
+	err := s.db.QueryRowContext(ctx,
+		"SELECT current_stock FROM products WHERE sku = $1", sku).Scan(&stock)
+	if err != nil {
+		return 0, err
+	}
+	return stock, nil
+}
+
+// AdvanceOffset stores topic/partition's consumed position on its own,
+// outside of ApplyUpdate's transaction, for messages that were
+// dead-lettered rather than applied.
+func (s *pgStore) AdvanceOffset(ctx context.Context, topic string, partition int32, offset int64) error {
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO consumer_offsets (topic, partition, "offset")
+		 VALUES ($1, $2, $3)
+		 ON CONFLICT (topic, partition) DO UPDATE SET
+		 "offset" = EXCLUDED."offset"`,
+		topic, partition, offset)
+	if err != nil {
+		return fmt.Errorf("failed to advance offset: %w", err)
+	}
+	return nil
+}
+
+// RelayOutboxBatch claims up to limit unpublished inventory_outbox rows with
+// SELECT ... FOR UPDATE SKIP LOCKED, so concurrent relay workers never claim
+// the same row, then holds that claim open across the publish call and only
+// marks the rows published (committing the transaction) once publish
+// succeeds. A publish error rolls the claim back, leaving the rows for the
+// next poll to retry.
+func (s *pgStore) RelayOutboxBatch(ctx context.Context, limit int, publish func(ids []int64, skus []string, deltas []int, newStocks []int) error) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx,
+		`SELECT id, sku, delta, new_stock FROM inventory_outbox
+		 WHERE published_at IS NULL
+		 ORDER BY id
+		 FOR UPDATE SKIP LOCKED
+		 LIMIT $1`, limit)
+	if err != nil {
+		return 0, fmt.Errorf("failed to claim outbox rows: %w", err)
+	}
+
+	var ids []int64
+	var skus []string
+	var deltas, newStocks []int
+	for rows.Next() {
+		var id int64
+		var sku string
+		var delta, newStock int
+		if err := rows.Scan(&id, &sku, &delta, &newStock); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan outbox row: %w", err)
+		}
+		ids = append(ids, id)
+		skus = append(skus, sku)
+		deltas = append(deltas, delta)
+		newStocks = append(newStocks, newStock)
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return 0, err
+	}
+	rows.Close()
+
+	if len(ids) == 0 {
+		return 0, tx.Commit()
+	}
+
+	if err := publish(ids, skus, deltas, newStocks); err != nil {
+		return 0, err
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE inventory_outbox SET published_at = now() WHERE id = ANY($1)`,
+		pq.Array(ids)); err != nil {
+		return 0, fmt.Errorf("failed to mark outbox rows published: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit outbox relay transaction: %w", err)
+	}
+	return len(ids), nil
+}
+
+// CountUnpublishedOutbox returns the current inventory_outbox backlog.
+func (s *pgStore) CountUnpublishedOutbox(ctx context.Context) (int, error) {
+	var count int
+	err := s.db.QueryRowContext(ctx,
+		"SELECT COUNT(*) FROM inventory_outbox WHERE published_at IS NULL").Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count outbox backlog: %w", err)
+	}
+	return count, nil
+}
+
+// LoadStoredOffsets returns the last stored offset per partition for topic,
+// used to resume newly assigned partitions at max(stored_offset)+1.
+func (s *pgStore) LoadStoredOffsets(ctx context.Context, topic string) (map[int32]int64, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT partition, "offset" FROM consumer_offsets WHERE topic = $1`, topic)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load stored offsets: %w", err)
+	}
+	defer rows.Close()
+
+	offsets := make(map[int32]int64)
+	for rows.Next() {
+		var partition int32
+		var offset int64
+		if err := rows.Scan(&partition, &offset); err != nil {
+			return nil, err
+		}
+		offsets[partition] = offset
+	}
+	return offsets, rows.Err()
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+
+	"inventory-consumer/tester"
+)
+
+// TestStressConcurrentUpdates drives RunStressTest against an in-memory
+// FakeStore, replacing the real Postgres instance the stress test used to
+// require.
+func TestStressConcurrentUpdates(t *testing.T) {
+	_, store := tester.New(t)
+	consumer := &InventoryConsumer{
+		store:        store,
+		metrics:      &Metrics{},
+		workerShards: DefaultWorkerShards,
+		retryConfig:  DefaultRetryConfig,
+		dlqProducer:  tester.NewFakeDLQProducer(),
+	}
+
+	config := StressTestConfig{
+		NumWorkers:          8,
+		OperationsPerWorker: 50,
+		Products:            []string{"SKU001", "SKU002", "SKU003"},
+		UpdateRange:         [2]int{1, 5},
+	}
+
+	metrics := RunStressTest(t, consumer, config)
+	if metrics.failedOperations > 0 {
+		t.Errorf("%d of %d operations failed", metrics.failedOperations, metrics.failedOperations+metrics.totalOperations)
+	}
+	if metrics.consistencyErrors > 0 {
+		t.Fatalf("detected %d consistency errors (negative stock)", metrics.consistencyErrors)
+	}
+}
+
+// TestPartitionReplayThroughConsumer pushes an event, consumes it through
+// the real ConsumeMessages pipeline, then uses FakeSource.Replay to
+// simulate the redelivery a rebalance can cause, asserting the event is
+// applied exactly once -- a rebalance-style replay exercised without a live
+// broker.
+func TestPartitionReplayThroughConsumer(t *testing.T) {
+	const topic = "inventory-events"
+	var partition int32 = 0
+
+	src, store := tester.New(t)
+	consumer := &InventoryConsumer{
+		consumer:       src,
+		store:          store,
+		metrics:        &Metrics{},
+		workerShards:   DefaultWorkerShards,
+		retryConfig:    DefaultRetryConfig,
+		dlqProducer:    tester.NewFakeDLQProducer(),
+		outboxConfig:   DefaultOutboxConfig,
+		outboxProducer: tester.NewFakeDLQProducer(),
+	}
+
+	event := InventoryEvent{ProductID: "SKU001", QuantityChange: 5, EventID: "evt-1"}
+	offset := src.Push(topic, partition, []byte(event.EventID), []byte{byte(event.QuantityChange)},
+		[]kafka.Header{{Key: "product_id", Value: []byte(event.ProductID)}})
+
+	consumeOnce := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 150*time.Millisecond)
+		defer cancel()
+		if err := consumer.ConsumeMessages(ctx, topic, AtLeastOnce); err != nil {
+			t.Fatalf("ConsumeMessages failed: %v", err)
+		}
+	}
+
+	consumeOnce()
+	if got := store.Stock("SKU001"); got != 5 {
+		t.Fatalf("after first consume: stock = %d, want 5", got)
+	}
+
+	if err := src.Replay(topic, partition, offset); err != nil {
+		t.Fatalf("Replay failed: %v", err)
+	}
+	consumeOnce()
+
+	if got := store.Stock("SKU001"); got != 5 {
+		t.Fatalf("after replay: stock = %d, want 5 (event should be a no-op the second time)", got)
+	}
+}
@@ -61,7 +61,7 @@ func RunStressTest(tb testing.TB, consumer *InventoryConsumer, config StressTest
 					EventID:        fmt.Sprintf("stress_%d_%d_%d", workerID, op, time.Now().UnixNano()),
 				}
 
-				err := consumer.UpdateInventory(ctx, event)
+				err := consumer.UpdateInventory(ctx, event, nil)
 				if err != nil {
 					atomic.AddInt64(&metrics.failedOperations, 1)
 					tb.Logf("Worker %d: Failed to update %s: %v", workerID, sku, err)
@@ -159,16 +159,3 @@ func MeasureContention(fn func(), duration time.Duration) float64 {
 	return opsPerSecond
 }
 
-// SimulateKafkaPartitionReplay simulates replaying messages from a Kafka partition
-func SimulateKafkaPartitionReplay(consumer *InventoryConsumer, partitionID int, messages []InventoryEvent) (successCount int, err error) {
-	ctx := context.Background()
-
-	for _, msg := range messages {
-		err := consumer.UpdateInventory(ctx, msg)
-		if err == nil {
-			successCount++
-		}
-	}
-
-	return successCount, nil
-}
@@ -0,0 +1,352 @@
+// Package tester provides in-memory stand-ins for the Kafka and Postgres
+// dependencies InventoryConsumer normally talks to, modeled after goka's
+// tester package, so tests can exercise the consumer with go test ./...
+// and no external services.
+package tester
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+// New returns a fresh FakeSource and FakeStore pair: push events onto the
+// source and assert against the store, exactly as you would against a real
+// Kafka topic and Postgres database.
+func New(t *testing.T) (*FakeSource, *FakeStore) {
+	t.Helper()
+	return newFakeSource(), newFakeStore()
+}
+
+// FakeSource is an in-memory substitute for a *kafka.Consumer. It implements
+// the same method set as InventoryConsumer's MessageSource dependency, so it
+// can be assigned directly in place of a real consumer in tests.
+type FakeSource struct {
+	mu     sync.Mutex
+	queue  map[int32][]*kafka.Message // messages in offset order, per partition
+	cursor map[int32]int              // next unread index per partition
+	closed bool
+}
+
+func newFakeSource() *FakeSource {
+	return &FakeSource{
+		queue:  make(map[int32][]*kafka.Message),
+		cursor: make(map[int32]int),
+	}
+}
+
+// SubscribeTopics is accepted for interface compatibility with
+// *kafka.Consumer; FakeSource only ever serves messages explicitly pushed
+// onto it, so there is nothing to subscribe to. Subscribing does reopen a
+// FakeSource that an earlier ConsumeMessages run closed, so the same source
+// (and its queued messages, e.g. after Replay) can be consumed again. It
+// cannot invoke the given kafka.RebalanceCb itself: the callback's signature
+// is hard-wired to a real *kafka.Consumer, which FakeSource has no way to
+// fabricate safely. The offset-seeking logic a rebalance drives is tested
+// directly instead -- see TestSeekTargetsResumesAtStoredOffsetPlusOne in
+// offsets_test.go.
+func (f *FakeSource) SubscribeTopics(topics []string, _ kafka.RebalanceCb) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.closed = false
+	return nil
+}
+
+// Push enqueues a message on partition as if a producer had just written it,
+// and returns the offset it was assigned.
+func (f *FakeSource) Push(topic string, partition int32, key, value []byte, headers []kafka.Header) int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	offset := int64(len(f.queue[partition]))
+	f.queue[partition] = append(f.queue[partition], &kafka.Message{
+		TopicPartition: kafka.TopicPartition{
+			Topic:     &topic,
+			Partition: partition,
+			Offset:    kafka.Offset(offset),
+		},
+		Key:     key,
+		Value:   value,
+		Headers: headers,
+	})
+	return offset
+}
+
+// Replay rewinds partition's read cursor back to offset, so the message
+// previously read from that position is delivered again -- simulating the
+// redelivery a consumer-group rebalance can cause.
+func (f *FakeSource) Replay(topic string, partition int32, offset int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	msgs := f.queue[partition]
+	if offset < 0 || int(offset) >= len(msgs) {
+		return fmt.Errorf("tester: no message at partition %d offset %d", partition, offset)
+	}
+	if f.cursor[partition] > int(offset) {
+		f.cursor[partition] = int(offset)
+	}
+	return nil
+}
+
+// ReadMessage returns the next queued message across all partitions,
+// blocking up to timeout while the queue is empty -- just like the real
+// consumer does.
+func (f *FakeSource) ReadMessage(timeout time.Duration) (*kafka.Message, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		if msg, ok := f.next(); ok {
+			return msg, nil
+		}
+		if time.Now().After(deadline) {
+			return nil, kafka.NewError(kafka.ErrTimedOut, "tester: read timed out", false)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func (f *FakeSource) next() (*kafka.Message, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return nil, false
+	}
+
+	partitions := make([]int32, 0, len(f.queue))
+	for p := range f.queue {
+		partitions = append(partitions, p)
+	}
+	sort.Slice(partitions, func(i, j int) bool { return partitions[i] < partitions[j] })
+
+	for _, p := range partitions {
+		next := f.cursor[p]
+		if next < len(f.queue[p]) {
+			f.cursor[p] = next + 1
+			return f.queue[p][next], true
+		}
+	}
+	return nil, false
+}
+
+// CommitMessage is a no-op: FakeSource has no committed-offset concept of
+// its own, since AtLeastOnce tests assert against the FakeStore instead.
+func (f *FakeSource) CommitMessage(msg *kafka.Message) ([]kafka.TopicPartition, error) {
+	return []kafka.TopicPartition{msg.TopicPartition}, nil
+}
+
+// Close stops ReadMessage from returning further messages.
+func (f *FakeSource) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.closed = true
+	return nil
+}
+
+// FakeStore is an in-memory substitute for the Postgres-backed
+// InventoryStore. It implements the same method set, so it can be assigned
+// directly in place of a real store in tests.
+type FakeStore struct {
+	mu           sync.Mutex
+	stock        map[string]int
+	processed    map[string]bool
+	offsets      map[string]map[int32]int64 // topic -> partition -> offset
+	outbox       []fakeOutboxRow
+	nextOutboxID int64
+}
+
+// fakeOutboxRow mirrors a row of the inventory_outbox table.
+type fakeOutboxRow struct {
+	id        int64
+	sku       string
+	delta     int
+	newStock  int
+	published bool
+}
+
+func newFakeStore() *FakeStore {
+	return &FakeStore{
+		stock:     make(map[string]int),
+		processed: make(map[string]bool),
+		offsets:   make(map[string]map[int32]int64),
+	}
+}
+
+// ApplyUpdate mirrors pgStore.ApplyUpdate's semantics against in-memory
+// maps instead of a real transaction: the CHECK (current_stock >= 0)
+// constraint is emulated by rejecting updates that would go negative.
+func (s *FakeStore) ApplyUpdate(ctx context.Context, eventID, sku string, delta int, hasOffset bool, offsetTopic string, offsetPartition int32, offsetOffset int64) (int, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.processed[eventID] {
+		return s.stock[sku], true, nil
+	}
+
+	newStock := s.stock[sku] + delta
+	if newStock < 0 {
+		return 0, false, fmt.Errorf("tester: update would take %s negative (CHECK current_stock >= 0 violation)", sku)
+	}
+
+	s.stock[sku] = newStock
+	s.processed[eventID] = true
+
+	if hasOffset {
+		if s.offsets[offsetTopic] == nil {
+			s.offsets[offsetTopic] = make(map[int32]int64)
+		}
+		s.offsets[offsetTopic][offsetPartition] = offsetOffset
+	}
+
+	s.nextOutboxID++
+	s.outbox = append(s.outbox, fakeOutboxRow{id: s.nextOutboxID, sku: sku, delta: delta, newStock: newStock})
+
+	return newStock, false, nil
+}
+
+func (s *FakeStore) GetStock(ctx context.Context, sku string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stock[sku], nil
+}
+
+func (s *FakeStore) LoadStoredOffsets(ctx context.Context, topic string) (map[int32]int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := make(map[int32]int64, len(s.offsets[topic]))
+	for partition, offset := range s.offsets[topic] {
+		out[partition] = offset
+	}
+	return out, nil
+}
+
+// AdvanceOffset stores topic/partition's consumed position on its own,
+// mirroring pgStore.AdvanceOffset, for messages that were dead-lettered
+// rather than applied.
+func (s *FakeStore) AdvanceOffset(ctx context.Context, topic string, partition int32, offset int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.offsets[topic] == nil {
+		s.offsets[topic] = make(map[int32]int64)
+	}
+	s.offsets[topic][partition] = offset
+	return nil
+}
+
+// RelayOutboxBatch mirrors pgStore.RelayOutboxBatch: it claims up to limit
+// unpublished rows, invokes publish, and only marks them published once
+// publish returns nil.
+func (s *FakeStore) RelayOutboxBatch(ctx context.Context, limit int, publish func(ids []int64, skus []string, deltas []int, newStocks []int) error) (int, error) {
+	s.mu.Lock()
+	var claimed []int
+	var ids []int64
+	var skus []string
+	var deltas, newStocks []int
+	for i := range s.outbox {
+		if s.outbox[i].published {
+			continue
+		}
+		claimed = append(claimed, i)
+		ids = append(ids, s.outbox[i].id)
+		skus = append(skus, s.outbox[i].sku)
+		deltas = append(deltas, s.outbox[i].delta)
+		newStocks = append(newStocks, s.outbox[i].newStock)
+		if len(claimed) == limit {
+			break
+		}
+	}
+	s.mu.Unlock()
+
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	if err := publish(ids, skus, deltas, newStocks); err != nil {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	for _, i := range claimed {
+		s.outbox[i].published = true
+	}
+	s.mu.Unlock()
+	return len(ids), nil
+}
+
+// CountUnpublishedOutbox returns the current in-memory outbox backlog.
+func (s *FakeStore) CountUnpublishedOutbox(ctx context.Context) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	count := 0
+	for _, row := range s.outbox {
+		if !row.published {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// Stock returns sku's current in-memory stock level, for test assertions.
+func (s *FakeStore) Stock(sku string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stock[sku]
+}
+
+// Processed reports whether eventID has already been applied, for test
+// assertions.
+func (s *FakeStore) Processed(eventID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.processed[eventID]
+}
+
+// FakeDLQProducer is an in-memory substitute for the *kafka.Producer
+// InventoryConsumer's retry subsystem dead-letters messages to. It
+// implements the same method set, so it can be assigned directly in place
+// of a real producer in tests.
+type FakeDLQProducer struct {
+	mu       sync.Mutex
+	messages []*kafka.Message
+}
+
+// NewFakeDLQProducer returns an empty FakeDLQProducer.
+func NewFakeDLQProducer() *FakeDLQProducer {
+	return &FakeDLQProducer{}
+}
+
+// Produce records msg and, if deliveryChan is non-nil, immediately reports
+// it delivered -- FakeDLQProducer has no broker round-trip to wait on.
+func (p *FakeDLQProducer) Produce(msg *kafka.Message, deliveryChan chan kafka.Event) error {
+	p.mu.Lock()
+	p.messages = append(p.messages, msg)
+	p.mu.Unlock()
+
+	if deliveryChan != nil {
+		deliveryChan <- &kafka.Message{TopicPartition: msg.TopicPartition}
+	}
+	return nil
+}
+
+// Messages returns a snapshot of every message produced so far, for test
+// assertions.
+func (p *FakeDLQProducer) Messages() []*kafka.Message {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	out := make([]*kafka.Message, len(p.messages))
+	copy(out, p.messages)
+	return out
+}
+
+// Len returns the number of messages produced so far.
+func (p *FakeDLQProducer) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.messages)
+}